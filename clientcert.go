@@ -0,0 +1,169 @@
+package restserver
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ClientCertUserField selects which field of a verified mTLS client
+// certificate maps to the private-repo username.
+type ClientCertUserField int
+
+const (
+	ClientCertUserFieldCN ClientCertUserField = iota
+	ClientCertUserFieldSANEmail
+	ClientCertUserFieldSANURI
+	ClientCertUserFieldOID
+)
+
+// ClientCertUserMapEntry maps client certificates whose subject matches
+// Regex to RepoName, so several service certificates can share one repo.
+type ClientCertUserMapEntry struct {
+	Regex    *regexp.Regexp
+	RepoName string
+}
+
+// ClientCertConfig configures how PrivateRepos derives a username from a
+// verified mTLS client certificate, for deployments such as this fork's
+// that run with NoAuth: true and have no htpasswd username to scope paths
+// by. It is wired up from Server by NewHandler when PrivateRepos is
+// enabled and NoAuth is true.
+type ClientCertConfig struct {
+	// UserField selects which part of the certificate identifies the
+	// user. Defaults to ClientCertUserFieldCN.
+	UserField ClientCertUserField
+	// OID is the attribute looked up when UserField is
+	// ClientCertUserFieldOID.
+	OID asn1.ObjectIdentifier
+	// UserMap is checked before UserField, so several service
+	// certificates can be routed to the same repo.
+	UserMap []ClientCertUserMapEntry
+}
+
+// ParseClientCertUserMap reads a --client-cert-user-map file: one
+// "cert-subject-regex = repo-name" mapping per line. Blank lines and lines
+// starting with "#" are ignored.
+func ParseClientCertUserMap(path string) ([]ClientCertUserMapEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("client-cert-user-map: %w", err)
+	}
+
+	var entries []ClientCertUserMapEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Split on the last "=" rather than the first: subject regexes
+		// routinely contain "=" themselves (e.g. matching "CN=...").
+		eq := strings.LastIndex(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("client-cert-user-map:%d: expected \"cert-subject-regex = repo-name\"", i+1)
+		}
+		pattern, repo := line[:eq], line[eq+1:]
+
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("client-cert-user-map:%d: %w", i+1, err)
+		}
+		entries = append(entries, ClientCertUserMapEntry{Regex: re, RepoName: strings.TrimSpace(repo)})
+	}
+	return entries, nil
+}
+
+// ParseOID parses a dotted-decimal OID such as "1.3.6.1.4.1.311.20.2.3".
+func ParseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q: %w", s, err)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// ClientCertUsername derives the private-repo username for a verified mTLS
+// client certificate. cfg.UserMap is checked first so that several
+// certificates can be routed to the same repo; it falls back to
+// cfg.UserField (optionally matched against cfg.OID when UserField is
+// ClientCertUserFieldOID).
+func ClientCertUsername(cert *x509.Certificate, cfg ClientCertConfig) (string, error) {
+	subject := cert.Subject.String()
+	for _, entry := range cfg.UserMap {
+		if entry.Regex.MatchString(subject) {
+			return entry.RepoName, nil
+		}
+	}
+
+	switch cfg.UserField {
+	case ClientCertUserFieldSANEmail:
+		if len(cert.EmailAddresses) == 0 {
+			return "", fmt.Errorf("client certificate %q has no SAN email address", subject)
+		}
+		return cert.EmailAddresses[0], nil
+	case ClientCertUserFieldSANURI:
+		if len(cert.URIs) == 0 {
+			return "", fmt.Errorf("client certificate %q has no SAN URI", subject)
+		}
+		return cert.URIs[0].String(), nil
+	case ClientCertUserFieldOID:
+		for _, name := range cert.Subject.Names {
+			if name.Type.Equal(cfg.OID) {
+				if v, ok := name.Value.(string); ok {
+					return v, nil
+				}
+			}
+		}
+		return "", fmt.Errorf("client certificate %q has no attribute for OID %v", subject, cfg.OID)
+	default:
+		if cert.Subject.CommonName == "" {
+			return "", fmt.Errorf("client certificate %q has no CN", subject)
+		}
+		return cert.Subject.CommonName, nil
+	}
+}
+
+// clientCertAuth derives the private-repo username from the verified mTLS
+// client certificate on the connection and attaches it via WithUsername, so
+// PrivateRepos scopes paths the same way it does for htpasswd or OIDC
+// callers. It falls through unmodified when no client certificate was
+// presented, leaving identity to whatever other auth proved it, but rejects
+// the request when a certificate was presented and no username could be
+// derived from it, mirroring oidcAuth's handling of an invalid Bearer
+// token.
+type clientCertAuth struct {
+	cfg  ClientCertConfig
+	next http.Handler
+}
+
+func NewClientCertAuth(cfg ClientCertConfig, next http.Handler) *clientCertAuth {
+	return &clientCertAuth{cfg: cfg, next: next}
+}
+
+func (c *clientCertAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+
+	username, err := ClientCertUsername(r.TLS.PeerCertificates[0], c.cfg)
+	if err != nil {
+		log.Printf("client-cert-user: rejecting certificate: %v", err)
+		http.Error(w, "could not derive username from client certificate", http.StatusForbidden)
+		return
+	}
+
+	c.next.ServeHTTP(w, WithUsername(r, username))
+}
@@ -0,0 +1,268 @@
+package restserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// issueTestCert generates a CA key pair and a leaf certificate signed by it
+// with the given subject and, optionally, SAN email/URI values.
+func issueTestCert(t *testing.T, cn string, email string, uri string) *x509.Certificate {
+	t.Helper()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if email != "" {
+		leafTemplate.EmailAddresses = []string{email}
+	}
+	if uri != "" {
+		u, err := url.Parse(uri)
+		if err != nil {
+			t.Fatalf("parse URI: %v", err)
+		}
+		leafTemplate.URIs = []*url.URL{u}
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("parse leaf cert: %v", err)
+	}
+	return leaf
+}
+
+// issueTestCertWithOID is like issueTestCert, but sets a custom subject
+// attribute for oid instead of a CN or SAN, for testing
+// ClientCertUserFieldOID.
+func issueTestCertWithOID(t *testing.T, oid asn1.ObjectIdentifier, value string) *x509.Certificate {
+	t.Helper()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			ExtraNames: []pkix.AttributeTypeAndValue{{Type: oid, Value: value}},
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(time.Hour),
+		KeyUsage:  x509.KeyUsageDigitalSignature,
+	}
+
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("parse leaf cert: %v", err)
+	}
+	return leaf
+}
+
+func TestClientCertUsername(t *testing.T) {
+	cnCert := issueTestCert(t, "alice", "", "")
+	emailCert := issueTestCert(t, "bob", "bob@example.com", "")
+	uriCert := issueTestCert(t, "carol", "", "spiffe://example.com/carol")
+
+	oid, err := ParseOID("1.2.840.113556.1.4.1337")
+	if err != nil {
+		t.Fatalf("parse OID: %v", err)
+	}
+	oidCert := issueTestCertWithOID(t, oid, "erin")
+
+	daveRe, err := regexp.Compile(`^CN=dave-\w+$`)
+	if err != nil {
+		t.Fatalf("compile user map regexp: %v", err)
+	}
+	daveUserMap := []ClientCertUserMapEntry{{Regex: daveRe, RepoName: "shared-dave"}}
+	daveServiceCert := issueTestCert(t, "dave-service1", "", "")
+
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		cfg      ClientCertConfig
+		wantUser string
+		wantErr  bool
+	}{
+		{name: "default CN", cert: cnCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldCN}, wantUser: "alice"},
+		{name: "SAN email", cert: emailCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldSANEmail}, wantUser: "bob@example.com"},
+		{name: "SAN email missing", cert: cnCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldSANEmail}, wantErr: true},
+		{name: "SAN URI", cert: uriCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldSANURI}, wantUser: "spiffe://example.com/carol"},
+		{name: "OID", cert: oidCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldOID, OID: oid}, wantUser: "erin"},
+		{name: "OID missing", cert: cnCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldOID, OID: oid}, wantErr: true},
+		{name: "user map match", cert: daveServiceCert, cfg: ClientCertConfig{UserField: ClientCertUserFieldCN, UserMap: daveUserMap}, wantUser: "shared-dave"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, err := ClientCertUsername(tt.cert, tt.cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ClientCertUsername: %v", err)
+			}
+			if username != tt.wantUser {
+				t.Errorf("username = %q, want %q", username, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestParseClientCertUserMap(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/user-map"
+	contents := "# comment\n\n^CN=dave-\\w+$ = shared-dave\n"
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write user map: %v", err)
+	}
+
+	entries, err := ParseClientCertUserMap(path)
+	if err != nil {
+		t.Fatalf("ParseClientCertUserMap: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	if entries[0].RepoName != "shared-dave" {
+		t.Errorf("RepoName = %q, want %q", entries[0].RepoName, "shared-dave")
+	}
+	if !entries[0].Regex.MatchString("CN=dave-service1") {
+		t.Errorf("regex %q did not match %q", entries[0].Regex, "CN=dave-service1")
+	}
+}
+
+func TestClientCertAuth(t *testing.T) {
+	cert := issueTestCert(t, "alice", "", "")
+
+	var gotUsername string
+	var gotOK bool
+	auth := NewClientCertAuth(ClientCertConfig{UserField: ClientCertUserFieldCN}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUsername, gotOK = Username(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/alice/config", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rr := httptest.NewRecorder()
+	auth.ServeHTTP(rr, req)
+
+	if !gotOK {
+		t.Fatal("expected username in request context")
+	}
+	if gotUsername != "alice" {
+		t.Errorf("username = %q, want %q", gotUsername, "alice")
+	}
+}
+
+func TestClientCertAuthNoCert(t *testing.T) {
+	calledNext := false
+	auth := NewClientCertAuth(ClientCertConfig{UserField: ClientCertUserFieldCN}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+		if _, ok := Username(r); ok {
+			t.Error("expected no username in request context")
+		}
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/alice/config", nil)
+	auth.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !calledNext {
+		t.Error("expected request without a client certificate to fall through to next")
+	}
+}
+
+func TestClientCertAuthNoUsername(t *testing.T) {
+	cert := issueTestCert(t, "", "", "")
+
+	calledNext := false
+	auth := NewClientCertAuth(ClientCertConfig{UserField: ClientCertUserFieldCN}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	rr := httptest.NewRecorder()
+	auth.ServeHTTP(rr, req)
+
+	if calledNext {
+		t.Error("expected request with an unmappable certificate to be rejected")
+	}
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager builds an autocert.Manager that provisions and renews TLS
+// certificates for hosts via ACME (e.g. Let's Encrypt), caching them under
+// cacheDir so operators don't have to generate cert files themselves.
+func newACMEManager(hosts []string, cacheDir, email string) (*autocert.Manager, error) {
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("--acme requires at least one --acme-host")
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/soheilhy/cmux"
+)
+
+// multiplexListener splits a single listener into a TLS sub-listener (for
+// the mTLS-authenticated repo API), a plain-HTTP/1.1 sub-listener (for
+// redirecting bare HTTP clients to https), and an HTTP/2 sub-listener
+// reserved for a future gRPC management API. The returned cmux.CMux must
+// be served (m.Serve()) for any of the three to make progress.
+func multiplexListener(listener net.Listener) (m cmux.CMux, tlsListener, httpListener, grpcListener net.Listener) {
+	m = cmux.New(listener)
+
+	// cmux.HTTP2() must be matched before cmux.HTTP1Fast() since a TLS
+	// client hello is otherwise indistinguishable from neither; matching
+	// order here mirrors cmux's own examples.
+	grpcListener = m.Match(cmux.HTTP2())
+	tlsListener = m.Match(cmux.TLS())
+	httpListener = m.Match(cmux.HTTP1Fast())
+
+	return m, tlsListener, httpListener, grpcListener
+}
+
+// serveHTTPSRedirect answers every plain-HTTP request with a 301 redirect
+// to the same host and path over https, so a TCP load balancer can expose
+// this single port without clients hitting a raw TLS handshake error.
+func serveHTTPSRedirect(listener net.Listener) error {
+	redirect := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := "https://" + r.Host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+	return http.Serve(listener, redirect)
+}
+
+// serveGRPCPlaceholder accepts connections matched as HTTP/2 and closes
+// them; no management API exists yet, but the matcher reserves the codec
+// so the future API doesn't need a protocol change on this port.
+func serveGRPCPlaceholder(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("grpc placeholder listener: %w", err)
+		}
+		conn.Close()
+	}
+}
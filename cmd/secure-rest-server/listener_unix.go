@@ -0,0 +1,45 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/coreos/go-systemd/activation"
+)
+
+// findListener creates a listener, adopting a systemd socket-activated
+// listener instead of binding a new one when one is available.
+func findListener(addr string) (listener net.Listener, err error) {
+	if addr == "systemd:" || systemdSocketActivated() {
+		listeners, err := activation.Listeners()
+		if err != nil {
+			return nil, fmt.Errorf("systemd socket activation failed: %w", err)
+		}
+		if len(listeners) != 1 {
+			return nil, fmt.Errorf("systemd socket activation: expected exactly 1 listener, got %d", len(listeners))
+		}
+
+		log.Info("adopted systemd socket-activated listener")
+		return listeners[0], nil
+	}
+
+	listener, err = net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %v failed: %w", addr, err)
+	}
+
+	log.Infof("start server on %v", addr)
+	return listener, nil
+}
+
+// systemdSocketActivated reports whether this process was started with
+// inherited file descriptors via systemd socket activation (LISTEN_FDS /
+// LISTEN_PID), independent of the configured --listen address.
+func systemdSocketActivated() bool {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	return err == nil && pid == os.Getpid() && os.Getenv("LISTEN_FDS") != ""
+}
@@ -1,19 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	restserver "github.com/restic/rest-server"
 	"github.com/spf13/cobra"
+	"golang.org/x/crypto/acme"
 
 	"github.com/saltsa/tlsauth"
 )
@@ -38,14 +44,31 @@ var server = restserver.Server{
 var log = tlsauth.GetLogger()
 
 var (
-	cpuProfile string
+	cpuProfile      string
+	shutdownTimeout time.Duration
+
+	oidcIssuer         string
+	oidcAudience       string
+	oidcRequiredClaims []string
+
+	clientCertUserField string
+	clientCertUserOID   string
+	clientCertUserMap   string
+
+	acmeEnabled bool
+	acmeHosts   []string
+	acmeCache   string
+	acmeEmail   string
+
+	metricsListen string
 )
 
 func init() {
 	flags := cmdRoot.Flags()
 	flags.StringVar(&cpuProfile, "cpu-profile", cpuProfile, "write CPU profile to file")
+	flags.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests to finish on SIGTERM/SIGINT before exiting")
 	flags.BoolVar(&server.Debug, "debug", server.Debug, "output debug messages")
-	flags.StringVar(&server.Listen, "listen", server.Listen, "listen address")
+	flags.StringVar(&server.Listen, "listen", server.Listen, "listen address (use \"systemd:\" to require a systemd socket-activated listener)")
 	flags.StringVar(&server.Log, "log", server.Log, "write HTTP requests in the combined log format to the specified `filename`")
 	flags.Int64Var(&server.MaxRepoSize, "max-size", server.MaxRepoSize, "the maximum size of the repository in bytes")
 	flags.StringVar(&server.Path, "path", server.Path, "data directory")
@@ -60,6 +83,68 @@ func init() {
 	flags.BoolVar(&server.PrivateRepos, "private-repos", server.PrivateRepos, "users can only access their private repo")
 	flags.BoolVar(&server.Prometheus, "prometheus", server.Prometheus, "enable Prometheus metrics")
 	flags.BoolVar(&server.PrometheusNoAuth, "prometheus-no-auth", server.PrometheusNoAuth, "disable auth for Prometheus /metrics endpoint")
+	flags.StringVar(&metricsListen, "metrics-listen", metricsListen, "serve /metrics on this address instead of the main --listen port, bypassing repo-api auth entirely")
+	flags.StringVar(&oidcIssuer, "oidc-issuer", oidcIssuer, "OIDC issuer URL; when set, Bearer tokens are accepted as an alternative to mTLS")
+	flags.StringVar(&oidcAudience, "oidc-audience", oidcAudience, "expected \"aud\" claim of OIDC Bearer tokens")
+	flags.StringSliceVar(&oidcRequiredClaims, "oidc-required-claim", oidcRequiredClaims, "required `claim=value` pair on OIDC Bearer tokens (can be repeated)")
+	flags.StringVar(&clientCertUserField, "client-cert-user-field", "cn", "client certificate field to use as the private-repo username when --private-repos is set without htpasswd: \"cn\", \"san-email\", \"san-uri\", or \"oid\"")
+	flags.StringVar(&clientCertUserOID, "client-cert-user-oid", clientCertUserOID, "dotted-decimal subject OID to read the username from, required when --client-cert-user-field=oid")
+	flags.StringVar(&clientCertUserMap, "client-cert-user-map", clientCertUserMap, "file of \"cert-subject-regex = repo-name\" lines, checked before --client-cert-user-field so several service certs can share a repo")
+	flags.BoolVar(&acmeEnabled, "acme", acmeEnabled, "provision the TLS certificate automatically via ACME instead of --tls-cert/--tls-key")
+	flags.StringSliceVar(&acmeHosts, "acme-host", acmeHosts, "hostname the ACME certificate is valid for (can be repeated)")
+	flags.StringVar(&acmeCache, "acme-cache", acmeCache, "directory to cache ACME account keys and certificates in")
+	flags.StringVar(&acmeEmail, "acme-email", acmeEmail, "contact email address to register with the ACME account")
+}
+
+func oidcConfig() (restserver.OIDCConfig, error) {
+	cfg := restserver.OIDCConfig{
+		Issuer:   oidcIssuer,
+		Audience: oidcAudience,
+	}
+	if len(oidcRequiredClaims) > 0 {
+		cfg.RequiredClaims = make(map[string]string, len(oidcRequiredClaims))
+		for _, pair := range oidcRequiredClaims {
+			claim, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return cfg, fmt.Errorf("--oidc-required-claim %q: expected claim=value", pair)
+			}
+			cfg.RequiredClaims[claim] = value
+		}
+	}
+	return cfg, nil
+}
+
+func clientCertConfig() (restserver.ClientCertConfig, error) {
+	var cfg restserver.ClientCertConfig
+	switch clientCertUserField {
+	case "", "cn":
+		cfg.UserField = restserver.ClientCertUserFieldCN
+	case "san-email":
+		cfg.UserField = restserver.ClientCertUserFieldSANEmail
+	case "san-uri":
+		cfg.UserField = restserver.ClientCertUserFieldSANURI
+	case "oid":
+		if clientCertUserOID == "" {
+			return cfg, errors.New("--client-cert-user-field=oid requires --client-cert-user-oid")
+		}
+		oid, err := restserver.ParseOID(clientCertUserOID)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.UserField = restserver.ClientCertUserFieldOID
+		cfg.OID = oid
+	default:
+		return cfg, fmt.Errorf("--client-cert-user-field %q: expected \"cn\", \"san-email\", \"san-uri\", or \"oid\"", clientCertUserField)
+	}
+
+	if clientCertUserMap != "" {
+		userMap, err := restserver.ParseClientCertUserMap(clientCertUserMap)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.UserMap = userMap
+	}
+	return cfg, nil
 }
 
 var version = "0.11.0"
@@ -71,6 +156,12 @@ func tlsSettings() (bool, string, string, error) {
 	} else if !server.TLS {
 		return false, "", "", nil
 	}
+	if acmeEnabled {
+		if server.TLSKey != "" || server.TLSCert != "" {
+			return false, "", "", errors.New("--acme is mutually exclusive with --tls-cert/--tls-key")
+		}
+		return server.TLS, "", "", nil
+	}
 	if server.TLSKey != "" {
 		key = server.TLSKey
 	} else {
@@ -88,6 +179,7 @@ func runRoot(cmd *cobra.Command, args []string) error {
 
 	log.Infof("Data directory: %s", server.Path)
 
+	var cpuProfileFile *os.File
 	if cpuProfile != "" {
 		f, err := os.Create(cpuProfile)
 		if err != nil {
@@ -97,21 +189,7 @@ func runRoot(cmd *cobra.Command, args []string) error {
 			return err
 		}
 		log.Info("CPU profiling enabled")
-
-		// clean profiling shutdown on sigint
-		sigintCh := make(chan os.Signal, 1)
-		go func() {
-			for range sigintCh {
-				pprof.StopCPUProfile()
-				log.Info("Stopped CPU profiling")
-				err := f.Close()
-				if err != nil {
-					log.Errorf("error closing CPU profile file: %v", err)
-				}
-				os.Exit(130)
-			}
-		}()
-		signal.Notify(sigintCh, syscall.SIGINT)
+		cpuProfileFile = f
 	}
 
 	if server.NoAuth {
@@ -125,6 +203,28 @@ func runRoot(cmd *cobra.Command, args []string) error {
 		log.Fatalf("error: %v", err)
 	}
 
+	var handler http.Handler = baseHandler
+	if server.PrivateRepos && server.NoAuth {
+		cfg, err := clientCertConfig()
+		if err != nil {
+			return err
+		}
+		handler = restserver.NewClientCertAuth(cfg, handler)
+		log.Infof("Private-repo username derived from client certificate field %q", clientCertUserField)
+	}
+
+	if oidcIssuer != "" {
+		cfg, err := oidcConfig()
+		if err != nil {
+			return err
+		}
+		handler, err = restserver.NewOIDCAuth(cfg, handler)
+		if err != nil {
+			return err
+		}
+		log.Infof("OIDC Bearer token authentication enabled, issuer %s", oidcIssuer)
+	}
+
 	if server.PrivateRepos {
 		log.Info("Private repositories enabled")
 	} else {
@@ -145,18 +245,124 @@ func runRoot(cmd *cobra.Command, args []string) error {
 		log.Fatalln("secure server doesn't work without TLS")
 	}
 
-	log.Infof("TLS enabled, private key %s, pubkey %v", privateKey, publicKey)
+	cm, tlsListener, httpListener, grpcListener := multiplexListener(listener)
+	go func() {
+		if err := serveHTTPSRedirect(httpListener); err != nil {
+			log.Errorf("plain-HTTP redirect listener: %v", err)
+		}
+	}()
+	go func() {
+		if err := serveGRPCPlaceholder(grpcListener); err != nil {
+			log.Infof("grpc placeholder listener stopped: %v", err)
+		}
+	}()
+	go func() {
+		if err := cm.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Errorf("cmux: %v", err)
+		}
+	}()
 
-	handler := tlsauth.TLSAuthMiddleware(baseHandler.ServeHTTP)
+	// --metrics-listen opens a second, independent net.Listen rather than
+	// a cmux sub-matcher on the main --listen port: cmux splits on
+	// connection-level protocol (TLS vs. plain HTTP1 vs. HTTP2), and
+	// /metrics needs splitting on HTTP path, which cmux can only do with
+	// a fragile byte-prefix matcher on the request line. A second address
+	// gets the same "bypass repo-API auth entirely" outcome without that
+	// fragility, at the cost of one more listening port.
+	if server.Prometheus && metricsListen != "" {
+		metricsListener, err := net.Listen("tcp", metricsListen)
+		if err != nil {
+			return fmt.Errorf("unable to listen for metrics: %w", err)
+		}
+		log.Infof("serving Prometheus metrics on %v", metricsListen)
+		go func() {
+			if err := http.Serve(metricsListener, promhttp.Handler()); err != nil {
+				log.Errorf("metrics listener: %v", err)
+			}
+		}()
+	}
+
+	// baseHandler itself serves /metrics on the main port when
+	// server.Prometheus is set (see server.go), gated the same way as the
+	// repo API; registering a second /metrics here would just shadow it.
+	// Only --metrics-listen above bypasses that gate, on its own port.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", restserver.HealthHandler)
+	mux.Handle("/", tlsauth.TLSAuthMiddleware(handler.ServeHTTP))
+
+	tlsConfig := &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+	}
+
+	if acmeEnabled {
+		if acmeCache == "" {
+			acmeCache = filepath.Join(server.Path, "acme-cache")
+		}
+		m, err := newACMEManager(acmeHosts, acmeCache, acmeEmail)
+		if err != nil {
+			return err
+		}
+		tlsConfig.GetCertificate = m.GetCertificate
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+
+		go func() {
+			if err := http.ListenAndServe(":80", m.HTTPHandler(nil)); err != nil {
+				log.Errorf("ACME HTTP-01 challenge listener on :80: %v", err)
+			}
+		}()
+
+		log.Infof("ACME autocert enabled for hosts %v, cache %s", acmeHosts, acmeCache)
+	} else {
+		log.Infof("TLS enabled, private key %s, pubkey %v", privateKey, publicKey)
+	}
 
 	srv := &http.Server{
-		Handler: handler,
-		TLSConfig: &tls.Config{
-			ClientAuth: tls.RequestClientCert,
-		},
+		Handler:   mux,
+		TLSConfig: tlsConfig,
 	}
 
-	err = srv.ServeTLS(listener, publicKey, privateKey)
+	shutdownCh := make(chan os.Signal, 1)
+	signal.Notify(shutdownCh, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-shutdownCh
+		log.Info("shutting down, draining in-flight requests")
+
+		if cpuProfileFile != nil {
+			pprof.StopCPUProfile()
+			if err := cpuProfileFile.Close(); err != nil {
+				log.Errorf("error closing CPU profile file: %v", err)
+			}
+			log.Info("stopped CPU profiling")
+		}
+
+		restserver.SetShuttingDown(true)
+		notifyStopping()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("error during graceful shutdown: %v", err)
+		}
+		cm.Close()
+
+		// signal.Notify above suppresses Go's default terminate-on-SIGTERM/
+		// SIGINT behavior, so this goroutine owns process exit from the
+		// moment it's registered: without this call, a build that reaches
+		// this point before srv.ServeTLS has returned on its own would just
+		// hang on Ctrl-C/systemctl stop instead of exiting.
+		os.Exit(0)
+	}()
+
+	watchdogDone := make(chan struct{})
+	go watchdogLoop(watchdogDone)
+	defer close(watchdogDone)
+
+	notifyReady()
+
+	err = srv.ServeTLS(tlsListener, publicKey, privateKey)
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 
 	return err
 }
@@ -165,4 +371,4 @@ func main() {
 	if err := cmdRoot.Execute(); err != nil {
 		log.Fatalf("error: %v", err)
 	}
-}
\ No newline at end of file
+}
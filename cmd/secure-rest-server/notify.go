@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/coreos/go-systemd/daemon"
+)
+
+// notifyReady tells systemd the server has finished initializing and is
+// ready to accept connections. It is a no-op when not running under a
+// Type=notify unit.
+func notifyReady() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyReady); err != nil {
+		log.Errorf("sd_notify READY failed: %v", err)
+	}
+}
+
+// notifyStopping tells systemd the server is shutting down.
+func notifyStopping() {
+	if _, err := daemon.SdNotify(false, daemon.SdNotifyStopping); err != nil {
+		log.Errorf("sd_notify STOPPING failed: %v", err)
+	}
+}
+
+// watchdogLoop pings systemd's watchdog at half of WATCHDOG_USEC until done
+// is closed. It returns immediately if the watchdog isn't enabled.
+func watchdogLoop(done <-chan struct{}) {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil || interval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog); err != nil {
+				log.Errorf("sd_notify WATCHDOG failed: %v", err)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package restserver
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// shuttingDown tracks whether the server is draining in-flight requests
+// before exit, for both the Prometheus gauge below and HealthHandler.
+var shuttingDown atomic.Bool
+
+var shuttingDownGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "rest_server_shutting_down",
+	Help: "1 if the server is draining in-flight requests before exit, 0 otherwise.",
+})
+
+func init() {
+	prometheus.MustRegister(shuttingDownGauge)
+}
+
+// SetShuttingDown records whether the server is currently draining
+// in-flight requests before exit, for the rest_server_shutting_down gauge
+// and HealthHandler below. Call it from the shutdown signal handler.
+func SetShuttingDown(v bool) {
+	shuttingDown.Store(v)
+	if v {
+		shuttingDownGauge.Set(1)
+	} else {
+		shuttingDownGauge.Set(0)
+	}
+}
+
+// HealthHandler reports 503 while the server is shutting down and 200
+// otherwise, so a load balancer stops routing new connections during
+// drain instead of having them time out.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Load() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,23 @@
+package restserver
+
+import (
+	"context"
+	"net/http"
+)
+
+type usernameContextKey struct{}
+
+// WithUsername attaches the authenticated username to r's context, for
+// PrivateRepos path scoping to read back via Username. It's used by every
+// auth method that can prove an identity other than htpasswd: OIDC bearer
+// tokens and mTLS client-certificate mapping.
+func WithUsername(r *http.Request, username string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), usernameContextKey{}, username))
+}
+
+// Username returns the username a request was authenticated as by one of
+// the non-htpasswd auth methods, if any.
+func Username(r *http.Request) (string, bool) {
+	username, ok := r.Context().Value(usernameContextKey{}).(string)
+	return username, ok
+}
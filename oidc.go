@@ -0,0 +1,242 @@
+package restserver
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// OIDCConfig configures bearer-token authentication against an external
+// OpenID Connect provider, used as an alternative to htpasswd. It is wired
+// up from Server by NewHandler when Issuer is non-empty.
+type OIDCConfig struct {
+	Issuer         string
+	Audience       string
+	RequiredClaims map[string]string
+	// UsernameClaim selects the token claim used as the authenticated
+	// username fed into the PrivateRepos path-scoping logic. Defaults to
+	// "sub".
+	UsernameClaim string
+}
+
+// oidcAuth validates Authorization: Bearer tokens and, on success, stores
+// the resulting username on the request context before calling next. It
+// falls through to next unmodified when no bearer token is present, so it
+// can be layered alongside mTLS: either proves identity.
+type oidcAuth struct {
+	cfg  OIDCConfig
+	jwks *jwksCache
+	next http.Handler
+}
+
+func NewOIDCAuth(cfg OIDCConfig, next http.Handler) (*oidcAuth, error) {
+	if cfg.Issuer == "" {
+		return nil, fmt.Errorf("oidc: issuer is required")
+	}
+	if cfg.UsernameClaim == "" {
+		cfg.UsernameClaim = "sub"
+	}
+	return &oidcAuth{
+		cfg:  cfg,
+		jwks: newJWKSCache(cfg.Issuer),
+		next: next,
+	}, nil
+}
+
+func (o *oidcAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		o.next.ServeHTTP(w, r)
+		return
+	}
+
+	username, err := o.authenticate(strings.TrimPrefix(auth, prefix))
+	if err != nil {
+		log.Printf("oidc: rejecting token: %v", err)
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+		return
+	}
+
+	o.next.ServeHTTP(w, WithUsername(r, username))
+}
+
+func (o *oidcAuth) authenticate(rawToken string) (string, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, o.keyFunc, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+	if err != nil {
+		return "", fmt.Errorf("parse token: %w", err)
+	}
+
+	if !claims.VerifyIssuer(o.cfg.Issuer, true) {
+		return "", fmt.Errorf("unexpected issuer %q", claims["iss"])
+	}
+	if o.cfg.Audience != "" && !claims.VerifyAudience(o.cfg.Audience, true) {
+		return "", fmt.Errorf("unexpected audience %q", claims["aud"])
+	}
+	for claim, want := range o.cfg.RequiredClaims {
+		if got, _ := claims[claim].(string); got != want {
+			return "", fmt.Errorf("claim %q: want %q, got %q", claim, want, got)
+		}
+	}
+
+	username, _ := claims[o.cfg.UsernameClaim].(string)
+	if username == "" {
+		return "", fmt.Errorf("token has no %q claim", o.cfg.UsernameClaim)
+	}
+	return username, nil
+}
+
+func (o *oidcAuth) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid header")
+	}
+	return o.jwks.key(kid)
+}
+
+// jwksCache fetches and caches a provider's JSON Web Key Set, refreshing it
+// on an unknown kid or once the cached set's max-age has elapsed.
+type jwksCache struct {
+	issuer string
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+	maxAge  time.Duration
+}
+
+func newJWKSCache(issuer string) *jwksCache {
+	return &jwksCache{issuer: issuer}
+}
+
+func (c *jwksCache) key(kid string) (interface{}, error) {
+	c.mu.Lock()
+	key, fresh := c.keys[kid], time.Since(c.fetched) < c.maxAge
+	c.mu.Unlock()
+	if key != nil && fresh {
+		return key, nil
+	}
+
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key = c.keys[kid]
+	if key == nil {
+		return nil, fmt.Errorf("jwks: unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (c *jwksCache) refresh() error {
+	jwksURI, err := c.discoverJWKSURI()
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Get(jwksURI)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: unexpected status %s", resp.Status)
+	}
+
+	var doc struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := rsaPublicKeyFromJWK(k.N, k.E)
+		if err != nil {
+			return fmt.Errorf("decode key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetched = time.Now()
+	c.maxAge = maxAgeFromHeader(resp.Header.Get("Cache-Control"))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *jwksCache) discoverJWKSURI() (string, error) {
+	resp, err := http.Get(strings.TrimSuffix(c.issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", fmt.Errorf("fetch discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", fmt.Errorf("decode discovery document: %w", err)
+	}
+	if doc.JWKSURI == "" {
+		return "", fmt.Errorf("discovery document has no jwks_uri")
+	}
+	return doc.JWKSURI, nil
+}
+
+// maxAgeFromHeader extracts max-age from a Cache-Control header, falling
+// back to a conservative default so a provider that omits caching hints
+// doesn't cause a JWKS fetch on every request.
+func maxAgeFromHeader(cacheControl string) time.Duration {
+	const prefix = "max-age="
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, prefix) {
+			if n, err := strconv.Atoi(directive[len(prefix):]); err == nil {
+				return time.Duration(n) * time.Second
+			}
+		}
+	}
+	return 5 * time.Minute
+}
+
+// rsaPublicKeyFromJWK decodes the base64url-encoded modulus and exponent of
+// an RSA JSON Web Key into an *rsa.PublicKey.
+func rsaPublicKeyFromJWK(n, e string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(n)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(e)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
@@ -0,0 +1,201 @@
+package restserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newFakeOIDCProvider starts a test server exposing a discovery document and
+// a JWKS endpoint for the given key, and returns a signer for that key.
+func newFakeOIDCProvider(t *testing.T, key *rsa.PrivateKey, kid string) (issuer string, sign func(jwt.MapClaims) string) {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"jwks_uri": srv.URL + "/jwks.json"})
+	})
+	mux.HandleFunc("/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		e := base64.RawURLEncoding.EncodeToString(big64(key.E))
+		w.Header().Set("Cache-Control", "max-age=60")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{"kid": kid, "kty": "RSA", "n": n, "e": e}},
+		})
+	})
+
+	sign = func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+		return signed
+	}
+
+	return srv.URL, sign
+}
+
+// big64 encodes a small exponent like 65537 as minimal big-endian bytes.
+func big64(e int) []byte {
+	b := make([]byte, 0, 4)
+	for shift := 24; shift >= 0; shift -= 8 {
+		if v := byte(e >> shift); v != 0 || len(b) > 0 {
+			b = append(b, v)
+		}
+	}
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+	return b
+}
+
+func TestOIDCAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	issuer, sign := newFakeOIDCProvider(t, key, "test-kid")
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   issuer,
+			"aud":   "restic-clients",
+			"sub":   "alice",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"group": "backup-admins",
+		}
+	}
+
+	tests := []struct {
+		name     string
+		claims   jwt.MapClaims
+		cfg      OIDCConfig
+		wantUser string
+		wantErr  bool
+	}{
+		{
+			name:     "valid token",
+			claims:   validClaims(),
+			cfg:      OIDCConfig{Issuer: issuer, Audience: "restic-clients"},
+			wantUser: "alice",
+		},
+		{
+			name:    "wrong audience",
+			claims:  validClaims(),
+			cfg:     OIDCConfig{Issuer: issuer, Audience: "other-clients"},
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			claims: func() jwt.MapClaims {
+				c := validClaims()
+				c["iss"] = "https://not-the-issuer.example"
+				return c
+			}(),
+			cfg:     OIDCConfig{Issuer: issuer},
+			wantErr: true,
+		},
+		{
+			name:   "missing required claim",
+			claims: validClaims(),
+			cfg: OIDCConfig{
+				Issuer:         issuer,
+				RequiredClaims: map[string]string{"group": "backup-admins", "tenant": "acme"},
+			},
+			wantErr: true,
+		},
+		{
+			name:   "required claim matches",
+			claims: validClaims(),
+			cfg: OIDCConfig{
+				Issuer:         issuer,
+				RequiredClaims: map[string]string{"group": "backup-admins"},
+			},
+			wantUser: "alice",
+		},
+		{
+			name: "expired token",
+			claims: func() jwt.MapClaims {
+				c := validClaims()
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+				return c
+			}(),
+			cfg:     OIDCConfig{Issuer: issuer},
+			wantErr: true,
+		},
+		{
+			name:     "custom username claim",
+			claims:   validClaims(),
+			cfg:      OIDCConfig{Issuer: issuer, UsernameClaim: "group"},
+			wantUser: "backup-admins",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth, err := NewOIDCAuth(tt.cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				username, ok := Username(r)
+				if !ok {
+					t.Error("expected username in request context")
+				}
+				if username != tt.wantUser {
+					t.Errorf("username = %q, want %q", username, tt.wantUser)
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			if err != nil {
+				t.Fatalf("NewOIDCAuth: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/alice/config", nil)
+			req.Header.Set("Authorization", "Bearer "+sign(tt.claims))
+			rr := httptest.NewRecorder()
+			auth.ServeHTTP(rr, req)
+
+			if tt.wantErr {
+				if rr.Code != http.StatusUnauthorized {
+					t.Errorf("status = %d, want %d", rr.Code, http.StatusUnauthorized)
+				}
+				return
+			}
+			if rr.Code != http.StatusOK {
+				dump, _ := httputil.DumpResponse(rr.Result(), true)
+				t.Errorf("status = %d, want 200:\n%s", rr.Code, dump)
+			}
+		})
+	}
+}
+
+func TestOIDCAuthNoBearerToken(t *testing.T) {
+	calledNext := false
+	auth, err := NewOIDCAuth(OIDCConfig{Issuer: "https://issuer.example"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledNext = true
+		if _, ok := Username(r); ok {
+			t.Error("expected no username in request context")
+		}
+	}))
+	if err != nil {
+		t.Fatalf("NewOIDCAuth: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/alice/config", nil)
+	auth.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !calledNext {
+		t.Error("expected request without a bearer token to fall through to next")
+	}
+}
@@ -0,0 +1,254 @@
+package restserver
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Server holds the configuration for the repository REST API handler built
+// by NewHandler. Its fields are bound directly to cmd/secure-rest-server's
+// flags; OIDC and mTLS client-certificate configuration are intentionally
+// not fields here; callers construct those auth layers themselves (see
+// oidc.go, clientcert.go) and wrap the handler NewHandler returns, the same
+// way cmd/secure-rest-server builds its tls.Config for ACME.
+type Server struct {
+	Debug            bool
+	Listen           string
+	Log              string
+	MaxRepoSize      int64
+	Path             string
+	TLS              bool
+	TLSCert          string
+	TLSKey           string
+	NoAuth           bool
+	HtpasswdPath     string
+	NoVerifyUpload   bool
+	AppendOnly       bool
+	PrivateRepos     bool
+	Prometheus       bool
+	PrometheusNoAuth bool
+}
+
+// handler serves the restic REST API (and, when cfg.Prometheus is set,
+// /metrics) out of cfg.Path. When cfg.PrivateRepos is set, it scopes every
+// request to the repo named after the authenticated username: htpasswd's
+// Basic Auth user when cfg.NoAuth is false, or whatever the caller's own
+// auth middleware attached via WithUsername when it's true.
+type handler struct {
+	cfg      *Server
+	htpasswd map[string][]byte // username -> bcrypt hash, nil when cfg.NoAuth
+}
+
+// NewHandler builds the http.Handler that serves cfg.Path as a restic REST
+// repository store. Callers needing OIDC or mTLS-derived identities wrap the
+// returned handler in their own middleware before serving it; see
+// NewOIDCAuth and NewClientCertAuth.
+func NewHandler(cfg *Server) (http.Handler, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("restserver: path is required")
+	}
+	if err := os.MkdirAll(cfg.Path, 0o700); err != nil {
+		return nil, fmt.Errorf("restserver: create data directory: %w", err)
+	}
+
+	h := &handler{cfg: cfg}
+	if !cfg.NoAuth {
+		path := cfg.HtpasswdPath
+		if path == "" {
+			path = filepath.Join(cfg.Path, ".htpasswd")
+		}
+		creds, err := loadHtpasswd(path)
+		if err != nil {
+			return nil, fmt.Errorf("restserver: load htpasswd file: %w", err)
+		}
+		h.htpasswd = creds
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.cfg.Prometheus && r.URL.Path == "/metrics" {
+		if !h.cfg.PrometheusNoAuth {
+			if _, ok := h.authenticate(w, r); !ok {
+				return
+			}
+		}
+		promhttp.Handler().ServeHTTP(w, r)
+		return
+	}
+
+	username, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	repo, rel, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if repo == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !ok {
+		rel = "."
+	}
+	if h.cfg.PrivateRepos && username != "" && username != repo {
+		http.Error(w, "repository access denied", http.StatusForbidden)
+		return
+	}
+
+	h.serveRepoFile(w, r, repo, rel)
+}
+
+// authenticate resolves the requesting user: via htpasswd Basic Auth when
+// cfg.NoAuth is false, or from whatever auth middleware upstream of this
+// handler has already attached via WithUsername when it's true (mTLS or
+// OIDC, or neither if private-repo scoping isn't in use). It writes an
+// error response and returns ok=false only when htpasswd auth is required
+// and missing or invalid.
+func (h *handler) authenticate(w http.ResponseWriter, r *http.Request) (username string, ok bool) {
+	if !h.cfg.NoAuth {
+		if !h.basicAuth(w, r) {
+			return "", false
+		}
+		username, _, _ = r.BasicAuth()
+		return username, true
+	}
+	username, _ = Username(r)
+	return username, true
+}
+
+func (h *handler) basicAuth(w http.ResponseWriter, r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if ok {
+		if hash, known := h.htpasswd[user]; known && bcrypt.CompareHashAndPassword(hash, []byte(pass)) == nil {
+			return true
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="restic-server"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (h *handler) serveRepoFile(w http.ResponseWriter, r *http.Request, repo, rel string) {
+	repoDir := filepath.Join(h.cfg.Path, repo)
+	full := filepath.Join(repoDir, filepath.FromSlash(rel))
+	if full != repoDir && !strings.HasPrefix(full, repoDir+string(filepath.Separator)) {
+		http.Error(w, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		http.ServeFile(w, r, full)
+	case http.MethodPost:
+		h.putRepoFile(w, r, full, rel)
+	case http.MethodDelete:
+		if h.cfg.AppendOnly {
+			http.Error(w, "append-only mode", http.StatusForbidden)
+			return
+		}
+		if err := os.Remove(full); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putRepoFile writes the request body to full, verifying that data blobs
+// are named after their own SHA-256 hash unless cfg.NoVerifyUpload opts out
+// of that check.
+func (h *handler) putRepoFile(w http.ResponseWriter, r *http.Request, full, rel string) {
+	if h.cfg.MaxRepoSize > 0 {
+		size, err := dirSize(filepath.Dir(full))
+		if err == nil && size+r.ContentLength > h.cfg.MaxRepoSize {
+			http.Error(w, "repository size limit exceeded", http.StatusInsufficientStorage)
+			return
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(full), 0o700); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Create(full)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hasher), r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !h.cfg.NoVerifyUpload && strings.HasPrefix(rel, "data/") {
+		if sum := hex.EncodeToString(hasher.Sum(nil)); filepath.Base(full) != sum {
+			os.Remove(full)
+			http.Error(w, fmt.Sprintf("uploaded blob hash %s does not match filename %s", sum, filepath.Base(full)), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func dirSize(dir string) (int64, error) {
+	var total int64
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// loadHtpasswd reads an Apache-style htpasswd file into a username -> bcrypt
+// hash map. A missing file is treated as no configured users, not an error,
+// so a fresh data directory can be bootstrapped before any are added.
+func loadHtpasswd(path string) (map[string][]byte, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return map[string][]byte{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	creds := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		creds[user] = []byte(hash)
+	}
+	return creds, scanner.Err()
+}
@@ -0,0 +1,47 @@
+package restserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerPrivateReposScoping(t *testing.T) {
+	dir := t.TempDir()
+	h, err := NewHandler(&Server{Path: dir, NoAuth: true, PrivateRepos: true})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/alice/config", strings.NewReader("x"))
+	req = WithUsername(req, "alice")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("own repo: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/bob/config", nil)
+	req = WithUsername(req, "alice")
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("other repo: status = %d, want %d", rr.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandlerUploadHashMismatch(t *testing.T) {
+	dir := t.TempDir()
+	h, err := NewHandler(&Server{Path: dir, NoAuth: true})
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/repo/data/deadbeef", strings.NewReader("not deadbeef"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusBadRequest)
+	}
+}